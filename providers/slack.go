@@ -4,46 +4,47 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
-	"path"
 	"strings"
 )
 
+// SlackProvider implements Sign In With Slack (OIDC), Slack's replacement
+// for the legacy identity.basic/oauth.access flow. Slack stopped allowing
+// new "classic" apps to request that flow after June 4, 2024, so this is
+// now the default "slack" provider; the old flow is still available as
+// "slack-legacy" via SlackLegacyProvider.
 type SlackProvider struct {
 	*ProviderData
 	TeamID  string
-	GroupID string
-}
+	TeamIDs []string
 
-// Slack API Response for https://api.slack.com/methods/users.identity
-type SlackUserIdentityResponse struct {
-	OK   bool
-	User SlackUserItem
-	Team SlackUserItem
-}
+	// TeamCredentials maps a team ID to the ClientID/ClientSecret of the
+	// Slack app that was used to install into that team, for deployments
+	// where each workspace (or batch of workspaces) has its own app
+	// registration rather than sharing ProviderData's single ClientID/
+	// ClientSecret. Installations tracks the same information once a team
+	// has actually completed the install flow, and both are consulted by
+	// Redeem to find credentials that can exchange a given code.
+	TeamCredentials      map[string]ClientCredentials
+	Installations        InstallationStore
+	installSigningSecret []byte
 
-type SlackUserItem struct {
-	ID    string
-	Name  string
-	Email string
+	// GroupIDs and UserGroupID gate login on users.conversations/
+	// usergroups.users.list membership; see slack_groups.go.
+	GroupIDs    []string
+	UserGroupID string
+	APIURL      *url.URL
 }
 
-type SlackGroupListResponse struct {
-	OK     bool
-	Groups []SlackGroupItem
-}
-
-// SlackGroupItem contains info about a group
-// This doesn't hold everything returned by the API, only the ID is needed
-type SlackGroupItem struct {
-	ID   string
-	Name string
-}
-
-type SlackAuthTestResponse struct {
-	OK bool
+// SlackUserInfoResponse is the OIDC-style response from
+// https://api.slack.com/methods/openid.connect.userInfo
+type SlackUserInfoResponse struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	TeamID        string `json:"https://slack.com/team_id"`
+	UserID        string `json:"https://slack.com/user_id"`
 }
 
 func NewSlackProvider(p *ProviderData) *SlackProvider {
@@ -52,62 +53,152 @@ func NewSlackProvider(p *ProviderData) *SlackProvider {
 		p.LoginURL = &url.URL{
 			Scheme: "https",
 			Host:   "slack.com",
-			Path:   "/oauth/authorize",
+			Path:   "/openid/connect/authorize",
 		}
 	}
 	if p.RedeemURL == nil || p.RedeemURL.String() == "" {
 		p.RedeemURL = &url.URL{
 			Scheme: "https",
 			Host:   "slack.com",
-			Path:   "/api/oauth.access",
+			Path:   "/api/openid.connect.token",
 		}
 	}
 	if p.ValidateURL == nil || p.ValidateURL.String() == "" {
 		p.ValidateURL = &url.URL{
 			Scheme: "https",
 			Host:   "slack.com",
-			Path:   "/api",
+			Path:   "/api/openid.connect.userInfo",
 		}
 	}
 	if p.Scope == "" {
-		p.Scope = "identity.basic identity.email"
+		// openid.connect.authorize only accepts OIDC scopes; the Web API
+		// scopes needed for GroupIDs/UserGroupID gates (see slack_groups.go)
+		// are appended by ensureScope only once a gate is actually
+		// configured, so a login that never gates on a group doesn't
+		// over-request consent.
+		p.Scope = "openid email profile"
+	}
+	return &SlackProvider{
+		ProviderData: p,
+		APIURL: &url.URL{
+			Scheme: "https",
+			Host:   "slack.com",
+			Path:   "/api",
+		},
 	}
-	return &SlackProvider{ProviderData: p}
 }
 
-// SetTeamID to check if the member is in the right team
+// SetTeamID restricts login to a single Slack workspace, checked against
+// the "https://slack.com/team_id" claim returned by openid.connect.userInfo.
 func (p *SlackProvider) SetTeamID(team string) {
 	if team != "" {
 		p.TeamID = team
-		// If a team id is set we can restrict login to this team directly at login
-		params, _ := url.ParseQuery(p.LoginURL.RawQuery)
-		params.Set("team", team)
-		p.LoginURL.RawQuery = params.Encode()
 	}
 }
 
-// SetGroupID to check if the member is in a given group
-func (p *SlackProvider) SetGroupID(group string) {
-	if group != "" {
-		p.GroupID = group
-	}
+// SetTeamIDs restricts login to an allow-list of Slack workspaces, for
+// multi-workspace apps. An entry of "*" allows any team; the requirement
+// that a team has completed the install flow is checked separately by
+// GetEmailAddress, and only when an InstallationStore is set. TeamIDs is
+// ignored while a single TeamID is set.
+func (p *SlackProvider) SetTeamIDs(teams []string) {
+	p.TeamIDs = teams
 }
 
-func (p *SlackProvider) getEndpoint(endpointName string, accessToken string, params url.Values, responseItem interface{}) (*http.Response, error) {
-	if params != nil {
-		params.Add("token", accessToken)
-	} else {
-		params = url.Values{
-			"token": {accessToken},
+// ensureScope appends any of extra's space-separated scopes not already
+// present in p.Scope, used to pull in the Web API scopes a group gate
+// needs without requesting them on logins that never configure one.
+func (p *SlackProvider) ensureScope(extra string) {
+	for _, s := range strings.Fields(extra) {
+		has := false
+		for _, existing := range strings.Fields(p.Scope) {
+			if existing == s {
+				has = true
+				break
+			}
+		}
+		if !has {
+			p.Scope += " " + s
 		}
 	}
-	endpoint := &url.URL{
-		Scheme:   p.ValidateURL.Scheme,
-		Host:     p.ValidateURL.Host,
-		Path:     path.Join(p.ValidateURL.Path, "/"+endpointName),
-		RawQuery: params.Encode(),
+}
+
+// SetInstallationStore configures where per-team client credentials are
+// looked up, enabling the /slack/install flow.
+func (p *SlackProvider) SetInstallationStore(store InstallationStore) {
+	p.Installations = store
+}
+
+// SetTeamCredentials configures the ClientID/ClientSecret to redeem codes
+// with for teams whose app registration differs from ProviderData's
+// default ClientID/ClientSecret.
+func (p *SlackProvider) SetTeamCredentials(creds map[string]ClientCredentials) {
+	p.TeamCredentials = creds
+}
+
+// SetInstallSigningSecret sets the HMAC secret used to sign and verify the
+// state parameter of the /slack/install redirect, binding it to the team
+// the install was started for.
+func (p *SlackProvider) SetInstallSigningSecret(secret []byte) {
+	p.installSigningSecret = secret
+}
+
+// InstallRedirectURL begins the OAuth dance for installing the proxy's
+// Slack app into teamID, to be served from a /slack/install endpoint. If
+// TeamCredentials has a distinct app registered for teamID, its ClientID
+// is used so the resulting code is redeemable with that same team's
+// ClientSecret; otherwise the default ProviderData ClientID is used.
+func (p *SlackProvider) InstallRedirectURL(redirectURI, teamID string) (string, error) {
+	if len(p.installSigningSecret) == 0 {
+		return "", fmt.Errorf("slack: no install signing secret configured")
+	}
+	clientID := p.ClientID
+	if creds, ok := p.TeamCredentials[teamID]; ok {
+		clientID = creds.ClientID
+	}
+	state := signInstallState(teamID, p.installSigningSecret)
+	return p.buildLoginURL(redirectURI, state, clientID), nil
+}
+
+// CompleteInstall verifies the signed install state returned alongside an
+// OAuth code and persists the client credentials the new team's tokens
+// must be redeemed with.
+func (p *SlackProvider) CompleteInstall(state string, creds ClientCredentials) error {
+	if p.Installations == nil {
+		return fmt.Errorf("slack: no installation store configured")
+	}
+	teamID, err := verifyInstallState(state, p.installSigningSecret)
+	if err != nil {
+		return err
+	}
+	return p.Installations.Save(&Installation{
+		TeamID:       teamID,
+		ClientID:     creds.ClientID,
+		ClientSecret: creds.ClientSecret,
+	})
+}
+
+// hasAllowedTeam reports whether teamID may log in, either because it
+// matches the legacy single-team TeamID, appears in the TeamIDs allow-list,
+// or the allow-list is "*".
+func (p *SlackProvider) hasAllowedTeam(teamID string) bool {
+	if p.TeamID != "" {
+		return teamID == p.TeamID
+	}
+	if len(p.TeamIDs) == 0 {
+		return true
+	}
+	for _, allowed := range p.TeamIDs {
+		if allowed == "*" || allowed == teamID {
+			return true
+		}
 	}
-	req, _ := http.NewRequest("GET", endpoint.String(), nil)
+	return false
+}
+
+func (p *SlackProvider) getUserInfo(accessToken string) (*SlackUserInfoResponse, error) {
+	req, _ := http.NewRequest("GET", p.ValidateURL.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -120,115 +211,73 @@ func (p *SlackProvider) getEndpoint(endpointName string, accessToken string, par
 	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf(
-			"got %d from %q %s", resp.StatusCode, endpoint.String(), body)
+			"got %d from %q %s", resp.StatusCode, p.ValidateURL.String(), body)
 	}
 
-	if err := json.Unmarshal(body, responseItem); err != nil {
+	var userInfo SlackUserInfoResponse
+	if err := json.Unmarshal(body, &userInfo); err != nil {
 		return nil, err
 	}
-	return resp, nil
+	return &userInfo, nil
 }
 
-func (p *SlackProvider) getIdentity(accessToken string) (*SlackUserIdentityResponse, error) {
-	var userIdentity SlackUserIdentityResponse
-	if _, err := p.getEndpoint("users.identity", accessToken, nil, &userIdentity); err != nil {
-		return nil, err
-	}
-
-	if userIdentity.OK == true {
-		return &userIdentity, nil
-	}
-	return nil, fmt.Errorf("slack response is not ok: %v", userIdentity)
-}
-
-func (p *SlackProvider) getGroups(accessToken string) (*SlackGroupListResponse, error) {
-	var groupList SlackGroupListResponse
-	if _, err := p.getEndpoint("groups.list", accessToken, url.Values{
-		"exclude_archived": {"true"},
-		"exclude_members":  {"true"},
-	}, &groupList); err != nil {
-		return nil, err
+func (p *SlackProvider) GetEmailAddress(s *SessionState) (string, error) {
+	userInfo, err := p.getUserInfo(s.AccessToken)
+	if err != nil {
+		return "", err
 	}
 
-	if groupList.OK == true {
-		return &groupList, nil
+	if !p.hasAllowedTeam(userInfo.TeamID) {
+		return "", fmt.Errorf("team id %s is not allowed to log in", userInfo.TeamID)
 	}
-	return nil, fmt.Errorf("slack response is not ok: %v", groupList)
-}
-
-func (p *SlackProvider) hasTeamID(resp *SlackUserIdentityResponse) bool {
-	return resp.Team.ID == p.TeamID
-}
 
-func (p *SlackProvider) hasGroupID(resp *SlackGroupListResponse) bool {
-	for _, group := range resp.Groups {
-		if group.ID == p.GroupID {
-			return true
+	if p.Installations != nil {
+		if _, err := p.Installations.Get(userInfo.TeamID); err != nil {
+			return "", fmt.Errorf("team id %s has not installed this app: %v", userInfo.TeamID, err)
 		}
 	}
-	return false
-}
 
-func (p *SlackProvider) GetEmailAddress(s *SessionState) (string, error) {
-	userIdentity, err := p.getIdentity(s.AccessToken)
-	if err != nil {
-		return "", nil
-	}
-
-	// if we require a TeamID, check that first
-	if p.TeamID != "" {
-		if ok := p.hasTeamID(userIdentity); !ok {
-			log.Printf("teamid: %s does not match with %s", userIdentity.Team.ID, p.TeamID)
-			return "", fmt.Errorf("team id doesn't match")
+	if len(p.GroupIDs) > 0 {
+		ok, err := p.hasGroupID(s.AccessToken)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("user is not a member of any of %v", p.GroupIDs)
 		}
 	}
-	// same for GroupID
-	if p.GroupID != "" {
-		groupList, err := p.getGroups(s.AccessToken)
+
+	if p.UserGroupID != "" {
+		ok, err := p.hasUserGroup(s.AccessToken)
 		if err != nil {
-			return "", nil
+			return "", err
 		}
-		if ok := p.hasGroupID(groupList); !ok {
-			log.Printf("groupid: %v does not match with %s", groupList.Groups, p.GroupID)
-			return "", fmt.Errorf("group id doesn't match")
+		if !ok {
+			return "", fmt.Errorf("user is not a member of usergroup %s", p.UserGroupID)
 		}
 	}
 
-	if email := userIdentity.User.Email; email != "" {
-		return email, nil
+	if userInfo.Email == "" {
+		return "", fmt.Errorf("no email returned by slack userinfo")
 	}
-
-	return "", nil
+	return userInfo.Email, nil
 }
 
-// SecondAttempt checks what scopes our token has by requesting a test endpoint and checking the headers
-// Returns true until all scopes are there
-func (p *SlackProvider) SecondAttempt(session *SessionState) bool {
-	resp, err := p.getEndpoint("auth.test", session.AccessToken, nil, &SlackAuthTestResponse{})
-	if err != nil {
-		return false
-	}
-	if strings.Contains(resp.Header.Get("X-Oauth-Scopes"), "groups") || p.GroupID == "" {
-		return false
-	}
-	return true
+// GetLoginURL with typical oauth parameters. Unlike the legacy provider,
+// v2 apps request all of their scopes up front, so there is no second
+// attempt with an upgraded scope.
+func (p *SlackProvider) GetLoginURL(redirectURI, state string, second bool) string {
+	return p.buildLoginURL(redirectURI, state, p.ClientID)
 }
 
-// GetLoginURL with typical oauth parameters
-// Requests a different scope on the second try since slack won't allow identity and "normal" scopes in the same request
-func (p *SlackProvider) GetLoginURL(redirectURI, state string, second bool) string {
+func (p *SlackProvider) buildLoginURL(redirectURI, state, clientID string) string {
 	var a url.URL
 	a = *p.LoginURL
 	params, _ := url.ParseQuery(a.RawQuery)
 	params.Set("redirect_uri", redirectURI)
-	params.Set("approval_prompt", p.ApprovalPrompt)
-	if second {
-		params.Set("scope", "groups:read")
-	} else {
-		params.Add("scope", p.Scope)
-	}
-	params.Set("client_id", p.ClientID)
 	params.Set("response_type", "code")
+	params.Set("scope", p.Scope)
+	params.Set("client_id", clientID)
 	params.Add("state", state)
 	a.RawQuery = params.Encode()
 	return a.String()
@@ -0,0 +1,203 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEventAge bounds how old an Events API delivery may be before it is
+// rejected, mirroring Slack's own signature-verification guidance.
+const maxEventAge = 5 * time.Minute
+
+// EventSink lets a provider react to out-of-band revocation signals (today
+// only SlackEventsHandler delivers to one) instead of only ever checking
+// membership during GetEmailAddress. Sessions should be treated as revoked
+// the moment InvalidateUser/InvalidateTeam is called, not just on next use.
+type EventSink interface {
+	InvalidateUser(userID string) error
+	InvalidateTeam(teamID string) error
+}
+
+// slackEventEnvelope is the subset of Slack's Events API payload shapes
+// (event callbacks and the one-time url_verification handshake) needed to
+// route revocation events. The inner event's shape varies by event.type,
+// so Event is decoded a second time per-type in dispatchEvent below.
+type slackEventEnvelope struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	TeamID    string          `json:"team_id"`
+	Event     json.RawMessage `json:"event"`
+
+	// Authorizations lists the installed users/bots this delivery was sent
+	// on behalf of. group_left/channel_left carry no user field inside
+	// Event at all - the leaving user is only identifiable because Slack
+	// fans the event out per-installation, so it's the authorized user here.
+	Authorizations []struct {
+		UserID string `json:"user_id"`
+	} `json:"authorizations"`
+}
+
+type slackEventType struct {
+	Type string `json:"type"`
+}
+
+// slackTokensRevokedEvent is the tokens_revoked payload: the affected user
+// IDs are under tokens.oauth/tokens.bot, not a top-level "user" field.
+type slackTokensRevokedEvent struct {
+	Tokens struct {
+		OAuth []string `json:"oauth"`
+		Bot   []string `json:"bot"`
+	} `json:"tokens"`
+}
+
+// slackUserChangeEvent is the user_change payload: "user" is a full user
+// object, not a bare user ID string.
+type slackUserChangeEvent struct {
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+}
+
+// SlackEventsHandler is an http.Handler for Slack's Events API webhook. It
+// verifies the v0 HMAC signature Slack attaches to every delivery and, on a
+// recognized revocation event, invalidates matching sessions via Sink.
+type SlackEventsHandler struct {
+	SigningSecret string
+	Sink          EventSink
+}
+
+func NewSlackEventsHandler(signingSecret string, sink EventSink) *SlackEventsHandler {
+	return &SlackEventsHandler{SigningSecret: signingSecret, Sink: sink}
+}
+
+func (h *SlackEventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.verifySignature(r.Header, body); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var envelope slackEventEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	// One-time handshake Slack performs when the webhook URL is first
+	// configured: echo the challenge back verbatim.
+	if envelope.Type == "url_verification" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(envelope.Challenge))
+		return
+	}
+
+	if h.Sink != nil {
+		if err := h.dispatchEvent(envelope); err != nil {
+			http.Error(w, "failed to invalidate session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// dispatchEvent decodes envelope.Event according to its type and
+// invalidates exactly the sessions that event reports as revoked. Only
+// team_access_revoked is genuinely team-wide; every other event must
+// resolve to a specific user ID rather than falling back to a team-wide
+// invalidation, or a single user's action (de-authorizing the app,
+// leaving a group) would tear down every other member's session too.
+func (h *SlackEventsHandler) dispatchEvent(envelope slackEventEnvelope) error {
+	var kind slackEventType
+	if err := json.Unmarshal(envelope.Event, &kind); err != nil {
+		return nil
+	}
+
+	switch kind.Type {
+	case "team_access_revoked":
+		return h.Sink.InvalidateTeam(envelope.TeamID)
+
+	case "tokens_revoked":
+		var event slackTokensRevokedEvent
+		if err := json.Unmarshal(envelope.Event, &event); err != nil {
+			return err
+		}
+		for _, userID := range append(event.Tokens.OAuth, event.Tokens.Bot...) {
+			if err := h.Sink.InvalidateUser(userID); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "user_change":
+		var event slackUserChangeEvent
+		if err := json.Unmarshal(envelope.Event, &event); err != nil {
+			return err
+		}
+		if event.User.ID == "" {
+			return nil
+		}
+		return h.Sink.InvalidateUser(event.User.ID)
+
+	case "group_left":
+		// group_left/channel_left carry no user field in Event - Slack
+		// fans this event out once per installed user/bot, so the
+		// leaving user is the one this particular delivery is authorized
+		// for, not anything found inside Event itself.
+		if len(envelope.Authorizations) == 0 || envelope.Authorizations[0].UserID == "" {
+			return fmt.Errorf("slack events: group_left delivery had no authorized user to invalidate")
+		}
+		return h.Sink.InvalidateUser(envelope.Authorizations[0].UserID)
+	}
+
+	return nil
+}
+
+// verifySignature implements Slack's v0 signing-secret scheme: the base
+// string is "v0:{timestamp}:{body}", HMAC-SHA256'd with the signing
+// secret and compared against X-Slack-Signature. Deliveries whose
+// timestamp is older than maxEventAge are rejected to guard against
+// replay attacks.
+func (h *SlackEventsHandler) verifySignature(header http.Header, body []byte) error {
+	if h.SigningSecret == "" {
+		return fmt.Errorf("slack events: no signing secret configured")
+	}
+
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	signature := header.Get("X-Slack-Signature")
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("missing slack signature headers")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > maxEventAge || age < -maxEventAge {
+		return fmt.Errorf("event timestamp too old")
+	}
+
+	base := "v0:" + timestamp + ":" + string(body)
+	mac := hmac.New(sha256.New, []byte(h.SigningSecret))
+	mac.Write([]byte(base))
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
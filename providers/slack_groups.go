@@ -0,0 +1,161 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// SetGroupID gates login on membership in one or more Slack conversations
+// (channels or private groups), given as a comma-separated list of channel
+// IDs. users.conversations replaces the deprecated groups.list, and unlike
+// groups.list it can also gate on public channels - it is membership-scoped
+// to the token's user for both public and private channels, unlike
+// conversations.list, which lists every public channel in the workspace
+// whether or not the user belongs to it.
+func (p *SlackProvider) SetGroupID(groups string) {
+	if groups == "" {
+		return
+	}
+	var ids []string
+	for _, id := range strings.Split(groups, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	p.GroupIDs = ids
+	p.ensureScope("channels:read groups:read")
+}
+
+// SetUserGroupID gates login on membership in a Slack user group (requires
+// the usergroups:read scope).
+func (p *SlackProvider) SetUserGroupID(userGroupID string) {
+	if userGroupID == "" {
+		return
+	}
+	p.UserGroupID = userGroupID
+	p.ensureScope("usergroups:read")
+}
+
+type slackUsersConversationsResponse struct {
+	OK       bool `json:"ok"`
+	Channels []struct {
+		ID string `json:"id"`
+	} `json:"channels"`
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+// listConversations walks users.conversations, following
+// response_metadata.next_cursor until exhausted, and returns the set of
+// conversation IDs the token's user is a member of. users.conversations is
+// used instead of conversations.list because conversations.list returns
+// every public channel in the workspace regardless of whether the token's
+// user is a member, which would let any workspace member pass a public
+// channel gate.
+func (p *SlackProvider) listConversations(accessToken string) (map[string]bool, error) {
+	ids := map[string]bool{}
+	cursor := ""
+	for {
+		params := url.Values{
+			"types":            {"private_channel,public_channel"},
+			"exclude_archived": {"true"},
+			"limit":            {"200"},
+		}
+		if cursor != "" {
+			params.Set("cursor", cursor)
+		}
+
+		var list slackUsersConversationsResponse
+		if err := p.callAPI("users.conversations", accessToken, params, &list); err != nil {
+			return nil, err
+		}
+		if !list.OK {
+			return nil, fmt.Errorf("slack users.conversations response was not ok")
+		}
+		for _, c := range list.Channels {
+			ids[c.ID] = true
+		}
+
+		cursor = list.ResponseMetadata.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	return ids, nil
+}
+
+func (p *SlackProvider) hasGroupID(accessToken string) (bool, error) {
+	member, err := p.listConversations(accessToken)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range p.GroupIDs {
+		if member[id] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type slackUserGroupsUsersListResponse struct {
+	OK    bool     `json:"ok"`
+	Users []string `json:"users"`
+}
+
+func (p *SlackProvider) hasUserGroup(accessToken string) (bool, error) {
+	var list slackUserGroupsUsersListResponse
+	if err := p.callAPI("usergroups.users.list", accessToken, url.Values{
+		"usergroup": {p.UserGroupID},
+	}, &list); err != nil {
+		return false, err
+	}
+	if !list.OK {
+		return false, fmt.Errorf("slack usergroups.users.list response was not ok")
+	}
+
+	userInfo, err := p.getUserInfo(accessToken)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range list.Users {
+		if id == userInfo.UserID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// callAPI performs a GET against a plain (non-OIDC) Slack Web API method
+// under APIURL, bearer-authenticated, used by the users.conversations/
+// usergroups gates that still speak the classic JSON envelope.
+func (p *SlackProvider) callAPI(method string, accessToken string, params url.Values, responseItem interface{}) error {
+	endpoint := &url.URL{
+		Scheme:   p.APIURL.Scheme,
+		Host:     p.APIURL.Host,
+		Path:     path.Join(p.APIURL.Path, method),
+		RawQuery: params.Encode(),
+	}
+	req, _ := http.NewRequest("GET", endpoint.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got %d from %q %s", resp.StatusCode, endpoint.String(), body)
+	}
+
+	return json.Unmarshal(body, responseItem)
+}
@@ -0,0 +1,240 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ClientCredentials holds the Client ID/Secret pair issued to a single
+// Slack app installation, so tokens minted for one workspace can be
+// redeemed even though a multi-workspace app shares one set of OAuth
+// endpoints across every team that installs it.
+type ClientCredentials struct {
+	ClientID     string
+	ClientSecret string
+}
+
+// Installation records the outcome of installing the proxy's Slack app
+// into a single workspace.
+type Installation struct {
+	TeamID       string `json:"team_id"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// InstallationStore persists one Installation per Slack team so that
+// SlackProvider can look up which credentials to use for a given team,
+// confirm that a team completed the install flow at all, and - via List -
+// find the right credentials to redeem a login whose team isn't known
+// until after the token exchange succeeds.
+type InstallationStore interface {
+	Get(teamID string) (*Installation, error)
+	Save(install *Installation) error
+	List() ([]*Installation, error)
+}
+
+// FileInstallationStore stores one JSON file per team under Dir. It is
+// the simplest InstallationStore and is suitable for single-instance
+// deployments or local testing.
+type FileInstallationStore struct {
+	Dir string
+}
+
+func NewFileInstallationStore(dir string) *FileInstallationStore {
+	return &FileInstallationStore{Dir: dir}
+}
+
+func (s *FileInstallationStore) path(teamID string) string {
+	return filepath.Join(s.Dir, teamID+".json")
+}
+
+func (s *FileInstallationStore) Get(teamID string) (*Installation, error) {
+	body, err := ioutil.ReadFile(s.path(teamID))
+	if err != nil {
+		return nil, err
+	}
+	var install Installation
+	if err := json.Unmarshal(body, &install); err != nil {
+		return nil, err
+	}
+	return &install, nil
+}
+
+func (s *FileInstallationStore) Save(install *Installation) error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	body, err := json.Marshal(install)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(install.TeamID), body, 0600)
+}
+
+func (s *FileInstallationStore) List() ([]*Installation, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	installs := make([]*Installation, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		body, err := ioutil.ReadFile(filepath.Join(s.Dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var install Installation
+		if err := json.Unmarshal(body, &install); err != nil {
+			return nil, err
+		}
+		installs = append(installs, &install)
+	}
+	return installs, nil
+}
+
+// RedisCmdable is the subset of a redis client SlackRedisInstallationStore
+// needs. It matches the method signatures of github.com/gomodule/redigo and
+// github.com/go-redis/redis clients closely enough to be satisfied by a thin
+// wrapper around either, without this package depending on one directly.
+type RedisCmdable interface {
+	Get(key string) (string, error)
+	Set(key string, value string) error
+}
+
+// RedisInstallationStore stores installations in Redis under a configurable
+// key prefix, for deployments that run more than one oauth2_proxy instance.
+type RedisInstallationStore struct {
+	Client RedisCmdable
+	Prefix string
+}
+
+func NewRedisInstallationStore(client RedisCmdable, prefix string) *RedisInstallationStore {
+	if prefix == "" {
+		prefix = "oauth2_proxy:slack:install:"
+	}
+	return &RedisInstallationStore{Client: client, Prefix: prefix}
+}
+
+func (s *RedisInstallationStore) key(teamID string) string {
+	return s.Prefix + teamID
+}
+
+// indexKey holds the JSON-encoded list of every team ID ever Saved, since
+// RedisCmdable is deliberately kept too narrow to require a real client's
+// SCAN/SMEMBERS support.
+func (s *RedisInstallationStore) indexKey() string {
+	return s.Prefix + "index"
+}
+
+func (s *RedisInstallationStore) Get(teamID string) (*Installation, error) {
+	body, err := s.Client.Get(s.key(teamID))
+	if err != nil {
+		return nil, err
+	}
+	var install Installation
+	if err := json.Unmarshal([]byte(body), &install); err != nil {
+		return nil, err
+	}
+	return &install, nil
+}
+
+func (s *RedisInstallationStore) Save(install *Installation) error {
+	body, err := json.Marshal(install)
+	if err != nil {
+		return err
+	}
+	if err := s.Client.Set(s.key(install.TeamID), string(body)); err != nil {
+		return err
+	}
+	return s.addToIndex(install.TeamID)
+}
+
+func (s *RedisInstallationStore) addToIndex(teamID string) error {
+	teamIDs, err := s.teamIDIndex()
+	if err != nil {
+		return err
+	}
+	for _, existing := range teamIDs {
+		if existing == teamID {
+			return nil
+		}
+	}
+	teamIDs = append(teamIDs, teamID)
+	body, err := json.Marshal(teamIDs)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(s.indexKey(), string(body))
+}
+
+func (s *RedisInstallationStore) teamIDIndex() ([]string, error) {
+	body, err := s.Client.Get(s.indexKey())
+	if err != nil {
+		return nil, nil
+	}
+	var teamIDs []string
+	if err := json.Unmarshal([]byte(body), &teamIDs); err != nil {
+		return nil, err
+	}
+	return teamIDs, nil
+}
+
+func (s *RedisInstallationStore) List() ([]*Installation, error) {
+	teamIDs, err := s.teamIDIndex()
+	if err != nil {
+		return nil, err
+	}
+	installs := make([]*Installation, 0, len(teamIDs))
+	for _, teamID := range teamIDs {
+		install, err := s.Get(teamID)
+		if err != nil {
+			continue
+		}
+		installs = append(installs, install)
+	}
+	return installs, nil
+}
+
+// signInstallState produces a signed "<team id>.<hmac>" state value (the
+// HMAC base64url-encoded without padding) so /slack/install can be
+// redeemed by the OAuth callback without server-side session storage. It
+// is HMAC'd with the secret passed in (the provider's install signing
+// secret) so a client cannot forge the target team.
+func signInstallState(teamID string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(teamID))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return teamID + "." + sig
+}
+
+// verifyInstallState reverses signInstallState, returning the team ID if
+// and only if the signature matches.
+func verifyInstallState(state string, secret []byte) (string, error) {
+	dot := -1
+	for i := len(state) - 1; i >= 0; i-- {
+		if state[i] == '.' {
+			dot = i
+			break
+		}
+	}
+	if dot < 0 {
+		return "", fmt.Errorf("malformed install state")
+	}
+	teamID := state[:dot]
+	if !hmac.Equal([]byte(signInstallState(teamID, secret)), []byte(state)) {
+		return "", fmt.Errorf("install state signature mismatch")
+	}
+	return teamID, nil
+}
@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SlackLegacyProvider implements the classic identity.basic/oauth.access
+// flow on top of RESTProvider. Slack stopped allowing new "classic" apps
+// to use this flow after June 4, 2024; it is kept around as "slack-legacy"
+// for apps that were already installed under it. New configurations
+// should use SlackProvider ("slack"), which speaks Sign In With Slack
+// (OIDC) instead.
+type SlackLegacyProvider struct {
+	*RESTProvider
+	TeamID  string
+	GroupID string
+}
+
+func NewSlackLegacyProvider(p *ProviderData) *SlackLegacyProvider {
+	p.ProviderName = "slack-legacy"
+	if p.LoginURL == nil || p.LoginURL.String() == "" {
+		p.LoginURL = &url.URL{
+			Scheme: "https",
+			Host:   "slack.com",
+			Path:   "/oauth/authorize",
+		}
+	}
+	if p.RedeemURL == nil || p.RedeemURL.String() == "" {
+		p.RedeemURL = &url.URL{
+			Scheme: "https",
+			Host:   "slack.com",
+			Path:   "/api/oauth.access",
+		}
+	}
+	if p.ValidateURL == nil || p.ValidateURL.String() == "" {
+		p.ValidateURL = &url.URL{
+			Scheme: "https",
+			Host:   "slack.com",
+			Path:   "/api",
+		}
+	}
+	if p.Scope == "" {
+		p.Scope = "identity.basic identity.email"
+	}
+	return &SlackLegacyProvider{
+		RESTProvider: NewRESTProvider(p, RESTConfig{
+			UserInfoURL:   "users.identity",
+			EmailJSONPath: "user.email",
+			Auth:          RESTAuth{QueryToken: "token"},
+		}),
+	}
+}
+
+// SetTeamID to check if the member is in the right team
+func (p *SlackLegacyProvider) SetTeamID(team string) {
+	if team != "" {
+		p.TeamID = team
+		// If a team id is set we can restrict login to this team directly at login
+		params, _ := url.ParseQuery(p.LoginURL.RawQuery)
+		params.Set("team", team)
+		p.LoginURL.RawQuery = params.Encode()
+	}
+	p.rebuildGates()
+}
+
+// SetGroupID to check if the member is in a given group
+func (p *SlackLegacyProvider) SetGroupID(group string) {
+	if group != "" {
+		p.GroupID = group
+	}
+	p.rebuildGates()
+}
+
+// rebuildGates keeps Config.Gates in sync with TeamID/GroupID, since
+// RESTProvider has no other way to express "this field may or may not be
+// required depending on provider configuration".
+func (p *SlackLegacyProvider) rebuildGates() {
+	var gates []RESTGate
+	if p.TeamID != "" {
+		gates = append(gates, RESTGate{URL: "users.identity", JSONPath: "team.id", Equals: p.TeamID})
+	}
+	if p.GroupID != "" {
+		gates = append(gates, RESTGate{URL: "groups.list", JSONPath: "groups.id", Contains: p.GroupID})
+	}
+	p.Config.Gates = gates
+}
+
+// SecondAttempt checks what scopes our token has by requesting a test endpoint and checking the headers
+// Returns true until all scopes are there
+func (p *SlackLegacyProvider) SecondAttempt(session *SessionState) bool {
+	endpoint := p.resolveURL("auth.test")
+	params := endpoint.Query()
+	params.Set("token", session.AccessToken)
+	endpoint.RawQuery = params.Encode()
+
+	req, _ := http.NewRequest("GET", endpoint.String(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if strings.Contains(resp.Header.Get("X-Oauth-Scopes"), "groups") || p.GroupID == "" {
+		return false
+	}
+	return true
+}
+
+// RevokeToken revokes the session's access token at Slack via auth.revoke,
+// so a logout from the proxy also ends the Slack-side grant.
+func (p *SlackLegacyProvider) RevokeToken(s *SessionState) error {
+	endpoint := p.resolveURL("auth.revoke")
+	params := endpoint.Query()
+	params.Set("token", s.AccessToken)
+	endpoint.RawQuery = params.Encode()
+
+	req, _ := http.NewRequest("POST", endpoint.String(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got %d revoking token from %q", resp.StatusCode, endpoint.String())
+	}
+
+	var revoked struct {
+		OK bool `json:"ok"`
+	}
+	if err := json.Unmarshal(body, &revoked); err != nil {
+		return err
+	}
+	if !revoked.OK {
+		return fmt.Errorf("slack auth.revoke response was not ok: %s", body)
+	}
+	return nil
+}
+
+// GetLoginURL with typical oauth parameters
+// Requests a different scope on the second try since slack won't allow identity and "normal" scopes in the same request
+func (p *SlackLegacyProvider) GetLoginURL(redirectURI, state string, second bool) string {
+	var a url.URL
+	a = *p.LoginURL
+	params, _ := url.ParseQuery(a.RawQuery)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("approval_prompt", p.ApprovalPrompt)
+	if second {
+		params.Set("scope", "groups:read")
+	} else {
+		params.Add("scope", p.Scope)
+	}
+	params.Set("client_id", p.ClientID)
+	params.Set("response_type", "code")
+	params.Add("state", state)
+	a.RawQuery = params.Encode()
+	return a.String()
+}
@@ -0,0 +1,106 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Redeem exchanges an authorization code for an access token. It overrides
+// the default single-ClientID/ClientSecret exchange: a multi-workspace
+// deployment may have installed under several different Slack apps (see
+// TeamCredentials/Installations), and which one issued a given code isn't
+// known until the exchange succeeds, so each configured credential set is
+// tried in turn.
+//
+// TeamCredentials and the persisted Installations are exactly the
+// credentials CompleteInstall and SetTeamCredentials populate - without
+// this, those credentials were stored but never read back anywhere.
+func (p *SlackProvider) Redeem(redirectURL, code string) (*SessionState, error) {
+	var lastErr error
+	for _, creds := range p.candidateCredentials() {
+		if creds.ClientID == "" || creds.ClientSecret == "" {
+			continue
+		}
+		session, err := p.redeemWithCredentials(redirectURL, code, creds)
+		if err == nil {
+			return session, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("slack: no client credentials configured to redeem code")
+	}
+	return nil, lastErr
+}
+
+// candidateCredentials returns every credential set worth trying: the
+// provider's own default first (the common case, and free of any lookup),
+// then TeamCredentials, then whatever has completed the install flow.
+func (p *SlackProvider) candidateCredentials() []ClientCredentials {
+	creds := []ClientCredentials{{ClientID: p.ClientID, ClientSecret: p.ClientSecret}}
+
+	for _, c := range p.TeamCredentials {
+		creds = append(creds, c)
+	}
+
+	if p.Installations != nil {
+		installs, err := p.Installations.List()
+		if err == nil {
+			for _, install := range installs {
+				creds = append(creds, ClientCredentials{
+					ClientID:     install.ClientID,
+					ClientSecret: install.ClientSecret,
+				})
+			}
+		}
+	}
+	return creds
+}
+
+type slackTokenResponse struct {
+	OK          bool   `json:"ok"`
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+}
+
+func (p *SlackProvider) redeemWithCredentials(redirectURL, code string, creds ClientCredentials) (*SessionState, error) {
+	params := url.Values{
+		"client_id":     {creds.ClientID},
+		"client_secret": {creds.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+	}
+
+	req, err := http.NewRequest("POST", p.RedeemURL.String(), strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("got %d from %q %s", resp.StatusCode, p.RedeemURL.String(), body)
+	}
+
+	var token slackTokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if !token.OK || token.AccessToken == "" {
+		return nil, fmt.Errorf("slack: token exchange failed: %s", token.Error)
+	}
+
+	return &SessionState{AccessToken: token.AccessToken}, nil
+}
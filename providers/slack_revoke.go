@@ -0,0 +1,47 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// RevokeToken revokes the session's access token at Slack via auth.revoke,
+// so a logout from the proxy also ends the Slack-side grant instead of
+// leaving it valid until it expires naturally. This matters most for
+// shared-device setups, where the cookie is gone but the token would
+// otherwise still work if it leaked.
+func (p *SlackProvider) RevokeToken(s *SessionState) error {
+	endpoint := &url.URL{
+		Scheme: "https",
+		Host:   "slack.com",
+		Path:   "/api/auth.revoke",
+	}
+	req, _ := http.NewRequest("POST", endpoint.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got %d from %q %s", resp.StatusCode, endpoint.String(), body)
+	}
+
+	var revoked struct {
+		OK bool `json:"ok"`
+	}
+	if err := json.Unmarshal(body, &revoked); err != nil {
+		return err
+	}
+	if !revoked.OK {
+		return fmt.Errorf("slack auth.revoke response was not ok: %s", body)
+	}
+	return nil
+}
@@ -1,30 +1,19 @@
 package providers
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
-	"path"
 )
 
+// SpacesProvider is a thin RESTProvider configuration: fetch the user's
+// profile, and if a SpaceID is set, gate login on a second request
+// confirming the user has access to that space.
 type SpacesProvider struct {
-	*ProviderData
-	APIUser  string
-	APISpace string
-	SpaceID  string
-}
-
-// SpacesUserIdentityResponse has basic user information
-type SpacesUserIdentityResponse struct {
-	ID    string
-	EMail string
-}
-
-// SpacesSpaceResponse has space information (if user has access)
-type SpacesSpaceResponse struct {
-	ID string // only thing that matters
+	*RESTProvider
+	SpaceID   string
+	RevokeURL string
 }
 
 func NewSpacesProvider(p *ProviderData) *SpacesProvider {
@@ -54,66 +43,58 @@ func NewSpacesProvider(p *ProviderData) *SpacesProvider {
 		p.Scope = "profile:read spaces:read"
 	}
 	return &SpacesProvider{
-		ProviderData: p,
-		APIUser:      "users/me/profile",
-		APISpace:     "spaces/%s", // space id placeholder
+		RESTProvider: NewRESTProvider(p, RESTConfig{
+			UserInfoURL: "users/me/profile",
+			// The baseline's SpacesUserIdentityResponse named this field
+			// EMail (not Email), which only makes sense if the API's JSON
+			// key is actually "eMail". lookupSegments now matches keys
+			// case-insensitively as encoding/json did, so this would also
+			// work as "email", but "eMail" documents the real key.
+			EmailJSONPath: "eMail",
+			Auth:          RESTAuth{Bearer: true},
+		}),
 	}
 }
 
-// SetSpaceID to check if the member is in the right team
+// SetSpaceID to check if the member is in the right space
 func (p *SpacesProvider) SetSpaceID(space string) {
 	p.SpaceID = space
+	if space == "" {
+		p.Config.Gates = nil
+		return
+	}
+	p.Config.Gates = []RESTGate{{
+		URL:      fmt.Sprintf("spaces/%s", space),
+		JSONPath: "id",
+		Equals:   space,
+	}}
 }
 
-func (p *SpacesProvider) getEndpoint(endpointName string, accessToken string, params url.Values, responseItem interface{}) (*http.Response, error) {
-	if params == nil {
-		params = url.Values{}
-	}
+// SetRevokeURL configures the endpoint RevokeToken posts to on logout.
+// Spaces has no documented revocation endpoint at the time of writing, so
+// this is opt-in and best-effort: if unset, RevokeToken is a no-op.
+func (p *SpacesProvider) SetRevokeURL(revokeURL string) {
+	p.RevokeURL = revokeURL
+}
 
-	endpoint := &url.URL{
-		Scheme:   p.ValidateURL.Scheme,
-		Host:     p.ValidateURL.Host,
-		Path:     path.Join(p.ValidateURL.Path, endpointName),
-		RawQuery: params.Encode(),
+// RevokeToken makes a best-effort attempt to revoke the session's access
+// token at Spaces on logout, if a RevokeURL has been configured. Failures
+// are logged, not returned: there is no documented Spaces revocation
+// endpoint to depend on, so this must never turn into a logout failure.
+func (p *SpacesProvider) RevokeToken(s *SessionState) error {
+	if p.RevokeURL == "" {
+		return nil
 	}
-	fmt.Println(accessToken)
-	req, _ := http.NewRequest("GET", endpoint.String(), nil)
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req, _ := http.NewRequest("POST", p.RevokeURL, nil)
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		log.Printf("spaces: best-effort token revocation failed: %v", err)
+		return nil
 	}
-
-	fmt.Println(resp)
-
-	body, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
-	if err != nil {
-		return nil, err
-	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf(
-			"got %d from %q %s", resp.StatusCode, endpoint.String(), body)
-	}
-
-	if err := json.Unmarshal(body, responseItem); err != nil {
-		return nil, err
-	}
-	return resp, nil
-}
-
-func (p *SpacesProvider) GetEmailAddress(s *SessionState) (string, error) {
-	var userIdentity SpacesUserIdentityResponse
-	if _, err := p.getEndpoint(p.APIUser, s.AccessToken, nil, &userIdentity); err != nil {
-		return "", err
-	}
-
-	// Check for the right space ID
-	if p.SpaceID != "" {
-		var spaceInformation SpacesSpaceResponse
-		if _, err := p.getEndpoint(fmt.Sprintf(p.APISpace, p.SpaceID), s.AccessToken, nil, &spaceInformation); err != nil {
-			return "", err
-		}
+		log.Printf("spaces: best-effort token revocation got %d from %q", resp.StatusCode, p.RevokeURL)
 	}
-	return userIdentity.EMail, nil
+	return nil
 }